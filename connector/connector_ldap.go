@@ -1,19 +1,25 @@
 package connector
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 
 	"gopkg.in/ldap.v2"
 
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 
 	"html/template"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/dex/pkg/log"
@@ -33,12 +39,17 @@ func init() {
 
 //LDAPConnectorConfig is the configuration for an ldap connector
 type LDAPConnectorConfig struct {
-	ID                   string        `json:"id"`
-	ServerHost           string        `json:"serverHost"`
-	ServerPort           uint16        `json:"serverPort"`
+	ID string `json:"id"`
+
+	//ServerURLs is an ordered list of LDAP server URLs, e.g.
+	//"ldap://host1:389" or "ldaps://host2:636". LDAPConnect tries each in
+	//turn, using the scheme to select LDAPS ("ldaps") or a plain connection
+	//("ldap"), the latter upgraded with StartTLS when UseTLS is set, and
+	//falling back to the default port for the scheme (389/636) when none is
+	//given.
+	ServerURLs           []string      `json:"serverURLs"`
 	Timeout              time.Duration `json:"timeout"`
 	UseTLS               bool          `json:"useTLS"`
-	UseSSL               bool          `json:"useSSL"`
 	CertFile             string        `json:"certFile"`
 	KeyFile              string        `json:"keyFile"`
 	CaFile               string        `json:"caFile"`
@@ -54,12 +65,123 @@ type LDAPConnectorConfig struct {
 	BindTemplate         string        `json:"bindTemplate"`
 	TrustedEmailProvider bool          `json:"trustedEmailProvider"`
 
-	//Attributes is a map of string to string, where the keys are the LDAP source
-	//of additional claims and the values are the JWT destination claim names
-	Attributes map[string]string `json:"attributes"`
+	//GroupSearchBase, GroupSearchFilter and GroupSearchScope configure a
+	//second search, bound as SearchBindDN/SearchBindPw, that enumerates the
+	//groups the authenticated user belongs to. GroupSearchFilter supports
+	//the "%u" (username) and "%d" (user DN) placeholders. The value of
+	//GroupNameAttribute on each matching entry is added to the identity's
+	//claims under GroupsClaim.
+	GroupSearchBase    string `json:"groupSearchBase"`
+	GroupSearchFilter  string `json:"groupSearchFilter"`
+	GroupSearchScope   string `json:"groupSearchScope"`
+	GroupNameAttribute string `json:"groupNameAttribute"`
+
+	//GroupMemberOfAttribute switches to a reverse lookup mode: instead of
+	//searching for group entries that reference the user, group DNs are
+	//read directly off this attribute (e.g. "memberOf") on the user's own
+	//entry, and GroupSearchBase/GroupSearchFilter are ignored.
+	GroupMemberOfAttribute string `json:"groupMemberOfAttribute"`
+
+	//GroupsClaim names the claim that resolved group names are added
+	//under. Defaults to "groups".
+	GroupsClaim string `json:"groupsClaim"`
+
+	//PoolSize, PoolIdleTimeout and PoolMaxLifetime configure the pool of
+	//pre-bound search connections kept open per server URL. Search
+	//connections are borrowed from and returned to this pool; the user bind
+	//always uses a fresh, short-lived connection instead. PoolIdleTimeout
+	//and PoolMaxLifetime are in the same units as Timeout.
+	PoolSize        int           `json:"poolSize"`
+	PoolIdleTimeout time.Duration `json:"poolIdleTimeout"`
+	PoolMaxLifetime time.Duration `json:"poolMaxLifetime"`
+
+	//SearchPageSize requests results from the user search using the LDAP
+	//paged-results control instead of a single unbounded search, so broad
+	//filters don't trip server-side size limits (e.g. AD's
+	//LDAP_SIZELIMIT_EXCEEDED). Defaults to 1000.
+	SearchPageSize uint32 `json:"searchPageSize"`
+
+	//Attributes is a map of LDAP source attribute name to the mapping that
+	//produces a claim from it. For backward compatibility a mapping may be
+	//given as a plain JSON string, equivalent to {"claim": "<string>"}.
+	Attributes map[string]AttributeMapping `json:"attributes"`
 	ldapAttrs  []string
 }
 
+//AttributeTransform* name the supported AttributeMapping.Transform values.
+const (
+	AttributeTransformRaw    = "raw"
+	AttributeTransformFirst  = "first"
+	AttributeTransformDNCN   = "dn_cn"
+	AttributeTransformBase64 = "base64"
+)
+
+//AttributeMapping maps a single LDAP source attribute to a claim, optionally
+//transforming its values first.
+type AttributeMapping struct {
+	//Claim is the destination claim name.
+	Claim string `json:"claim"`
+
+	//Transform is one of AttributeTransformRaw (default, values unchanged),
+	//AttributeTransformFirst (keep only the first value), AttributeTransformDNCN
+	//(parse each value as a DN and emit the value of its leading RDN, e.g. the
+	//CN of a memberOf value; the RDN's attribute type is not checked),
+	//or AttributeTransformBase64 (base64-encode each value, for
+	//binary attributes such as objectGUID/objectSid that are not valid UTF-8).
+	Transform string `json:"transform"`
+
+	//Single emits the first transformed value as a scalar claim instead of
+	//a list of all transformed values.
+	Single bool `json:"single"`
+}
+
+//UnmarshalJSON accepts either the current object form or a bare JSON string,
+//the latter treated as {"claim": "<string>"} for compatibility with
+//pre-existing Attributes configuration.
+func (m *AttributeMapping) UnmarshalJSON(data []byte) error {
+	var claim string
+	if err := json.Unmarshal(data, &claim); err == nil {
+		*m = AttributeMapping{Claim: claim}
+		return nil
+	}
+
+	type plain AttributeMapping
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*m = AttributeMapping(p)
+	return nil
+}
+
+//transformValues applies m.Transform to values, returning the values to add
+//to the claim.
+func (m AttributeMapping) transformValues(values []string) ([]string, error) {
+	switch m.Transform {
+	case "", AttributeTransformRaw:
+		return values, nil
+	case AttributeTransformFirst:
+		if len(values) == 0 {
+			return nil, nil
+		}
+		return values[:1], nil
+	case AttributeTransformDNCN:
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = groupNameFromDN(v)
+		}
+		return out, nil
+	case AttributeTransformBase64:
+		out := make([]string, len(values))
+		for i, v := range values {
+			out[i] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unknown attribute transform %q", m.Transform)
+	}
+}
+
 //ConnectorID implements ConnectorConfig.ConnectorID
 func (cfg *LDAPConnectorConfig) ConnectorID() string {
 	return cfg.ID
@@ -93,9 +215,15 @@ func (cfg *LDAPConnectorConfig) Connector(ns url.URL, lf oidc.LoginFunc, tpls *t
 	const defaultEmailAttribute = "mail"
 	const defaultBindTemplate = "uid=%u,%b"
 	const defaultSearchScope = ldap.ScopeWholeSubtree
-
-	if cfg.UseTLS && cfg.UseSSL {
-		return nil, fmt.Errorf("Invalid configuration. useTLS and useSSL are mutual exclusive.")
+	const defaultGroupNameAttribute = "cn"
+	const defaultGroupsClaim = "groups"
+	const defaultPoolSize = 10
+	const defaultPoolIdleTimeout = 5 * 60 * 1000  // 5 minutes, same units as Timeout
+	const defaultPoolMaxLifetime = 30 * 60 * 1000 // 30 minutes, same units as Timeout
+	const defaultSearchPageSize = 1000
+
+	if len(cfg.ServerURLs) == 0 {
+		return nil, fmt.Errorf("Invalid configuration. serverURLs must contain at least one LDAP URL.")
 	}
 
 	if len(cfg.CertFile) > 0 && len(cfg.KeyFile) == 0 {
@@ -120,30 +248,57 @@ func (cfg *LDAPConnectorConfig) Connector(ns url.URL, lf oidc.LoginFunc, tpls *t
 		bindTemplate = cfg.BindTemplate
 	}
 
-	searchScope := defaultSearchScope
-	if len(cfg.SearchScope) > 0 {
-		switch {
-		case strings.EqualFold(cfg.SearchScope, "BASE"):
-			searchScope = ldap.ScopeBaseObject
-		case strings.EqualFold(cfg.SearchScope, "ONE"):
-			searchScope = ldap.ScopeSingleLevel
-		case strings.EqualFold(cfg.SearchScope, "SUB"):
-			searchScope = ldap.ScopeWholeSubtree
-		default:
-			return nil, fmt.Errorf("Invalid value for searchScope: '%v'. Must be one of 'base', 'one' or 'sub'.", cfg.SearchScope)
-		}
+	searchScope, err := parseSearchScope(cfg.SearchScope, defaultSearchScope)
+	if err != nil {
+		return nil, err
+	}
+
+	groupSearchScope, err := parseSearchScope(cfg.GroupSearchScope, defaultSearchScope)
+	if err != nil {
+		return nil, err
+	}
+
+	groupNameAttribute := defaultGroupNameAttribute
+	if len(cfg.GroupNameAttribute) > 0 {
+		groupNameAttribute = cfg.GroupNameAttribute
+	}
+
+	groupsClaim := defaultGroupsClaim
+	if len(cfg.GroupsClaim) > 0 {
+		groupsClaim = cfg.GroupsClaim
+	}
+
+	poolSize := defaultPoolSize
+	if cfg.PoolSize > 0 {
+		poolSize = cfg.PoolSize
+	}
+
+	poolIdleTimeout := time.Duration(defaultPoolIdleTimeout) * time.Millisecond
+	if cfg.PoolIdleTimeout > 0 {
+		poolIdleTimeout = cfg.PoolIdleTimeout * time.Millisecond
+	}
+
+	poolMaxLifetime := time.Duration(defaultPoolMaxLifetime) * time.Millisecond
+	if cfg.PoolMaxLifetime > 0 {
+		poolMaxLifetime = cfg.PoolMaxLifetime * time.Millisecond
+	}
+
+	searchPageSize := uint32(defaultSearchPageSize)
+	if cfg.SearchPageSize > 0 {
+		searchPageSize = cfg.SearchPageSize
 	}
 
 	if cfg.Timeout != 0 {
 		ldap.DefaultTimeout = cfg.Timeout * time.Millisecond
 	}
 
+	// ServerName is left unset here and filled in per-dial, since ServerURLs
+	// may name more than one host.
 	tlsConfig := &tls.Config{
-		ServerName:         cfg.ServerHost,
 		InsecureSkipVerify: cfg.SkipCertVerification,
 	}
 
-	if (cfg.UseTLS || cfg.UseSSL) && len(cfg.CaFile) > 0 {
+	if len(cfg.CaFile) > 0 {
 		buf, err := ioutil.ReadFile(cfg.CaFile)
 		if err != nil {
 			return nil, err
@@ -158,7 +313,7 @@ func (cfg *LDAPConnectorConfig) Connector(ns url.URL, lf oidc.LoginFunc, tpls *t
 		}
 	}
 
-	if (cfg.UseTLS || cfg.UseSSL) && len(cfg.CertFile) > 0 && len(cfg.KeyFile) > 0 {
+	if len(cfg.CertFile) > 0 && len(cfg.KeyFile) > 0 {
 		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 		if err != nil {
 			return nil, err
@@ -171,20 +326,26 @@ func (cfg *LDAPConnectorConfig) Connector(ns url.URL, lf oidc.LoginFunc, tpls *t
 		cfg.ldapAttrs = append(cfg.ldapAttrs, key)
 	}
 	idp := &LDAPIdentityProvider{
-		cfg:              *cfg,
-		serverHost:       cfg.ServerHost,
-		serverPort:       cfg.ServerPort,
-		useTLS:           cfg.UseTLS,
-		useSSL:           cfg.UseSSL,
-		nameAttribute:    nameAttribute,
-		emailAttribute:   emailAttribute,
-		searchBeforeAuth: cfg.SearchBeforeAuth,
-		searchFilter:     cfg.SearchFilter,
-		searchScope:      searchScope,
-		searchBindDN:     cfg.SearchBindDN,
-		searchBindPw:     cfg.SearchBindPw,
-		bindTemplate:     bindTemplate,
-		tlsConfig:        tlsConfig,
+		cfg:                    *cfg,
+		serverURLs:             cfg.ServerURLs,
+		useTLS:                 cfg.UseTLS,
+		nameAttribute:          nameAttribute,
+		emailAttribute:         emailAttribute,
+		searchBeforeAuth:       cfg.SearchBeforeAuth,
+		searchFilter:           cfg.SearchFilter,
+		searchScope:            searchScope,
+		searchBindDN:           cfg.SearchBindDN,
+		searchBindPw:           cfg.SearchBindPw,
+		bindTemplate:           bindTemplate,
+		tlsConfig:              tlsConfig,
+		groupSearchBase:        cfg.GroupSearchBase,
+		groupSearchFilter:      cfg.GroupSearchFilter,
+		groupSearchScope:       groupSearchScope,
+		groupNameAttribute:     groupNameAttribute,
+		groupMemberOfAttribute: cfg.GroupMemberOfAttribute,
+		groupsClaim:            groupsClaim,
+		pool:                   newLDAPConnPool(poolSize, poolIdleTimeout, poolMaxLifetime),
+		searchPageSize:         searchPageSize,
 	}
 
 	idpc := &LDAPConnector{
@@ -199,16 +360,42 @@ func (cfg *LDAPConnectorConfig) Connector(ns url.URL, lf oidc.LoginFunc, tpls *t
 	return idpc, nil
 }
 
+//parseSearchScope converts the "base", "one" or "sub" config string into the
+//corresponding ldap.Scope* constant, returning def when scope is empty.
+func parseSearchScope(scope string, def int) (int, error) {
+	if len(scope) == 0 {
+		return def, nil
+	}
+	switch {
+	case strings.EqualFold(scope, "BASE"):
+		return ldap.ScopeBaseObject, nil
+	case strings.EqualFold(scope, "ONE"):
+		return ldap.ScopeSingleLevel, nil
+	case strings.EqualFold(scope, "SUB"):
+		return ldap.ScopeWholeSubtree, nil
+	default:
+		return 0, fmt.Errorf("Invalid value for searchScope: '%v'. Must be one of 'base', 'one' or 'sub'.", scope)
+	}
+}
+
 func (c *LDAPConnector) ID() string {
 	return c.id
 }
 
 func (c *LDAPConnector) Healthy() error {
-	ldapConn, err := c.idp.LDAPConnect()
-	if err == nil {
+	// Configs that neither search before auth nor resolve groups never
+	// borrow from the pool, so exercise a plain dial rather than binding as
+	// the (possibly unconfigured) search user.
+	if !c.idp.searchBeforeAuth && !c.idp.groupsConfigured() {
+		ldapConn, err := c.idp.LDAPConnect()
+		if err != nil {
+			return err
+		}
 		ldapConn.Close()
+		return nil
 	}
-	return err
+
+	return c.idp.withPool(func(*ldap.Conn) error { return nil })
 }
 
 func (c *LDAPConnector) LoginURL(sessionKey, prompt string) (string, error) {
@@ -235,10 +422,8 @@ func (c *LDAPConnector) TrustedEmailProvider() bool {
 
 type LDAPIdentityProvider struct {
 	cfg              LDAPConnectorConfig
-	serverHost       string
-	serverPort       uint16
+	serverURLs       []string
 	useTLS           bool
-	useSSL           bool
 	baseDN           string
 	nameAttribute    string
 	emailAttribute   string
@@ -249,32 +434,321 @@ type LDAPIdentityProvider struct {
 	searchBindPw     string
 	bindTemplate     string
 	tlsConfig        *tls.Config
+
+	//groupSearchBase, groupSearchFilter and groupSearchScope drive the
+	//post-auth group search; groupMemberOfAttribute switches to reverse
+	//(memberOf-style) lookup instead, ignoring the two group search fields.
+	groupSearchBase        string
+	groupSearchFilter      string
+	groupSearchScope       int
+	groupNameAttribute     string
+	groupMemberOfAttribute string
+	groupsClaim            string
+
+	//pool hands out pre-bound search connections, keyed by server URL.
+	pool *ldapConnPool
+
+	//searchPageSize is the page size used for the user search via the LDAP
+	//paged-results control.
+	searchPageSize uint32
+}
+
+//groupsConfigured reports whether either group lookup mode is enabled.
+func (m *LDAPIdentityProvider) groupsConfigured() bool {
+	return len(m.groupMemberOfAttribute) > 0 || len(m.groupSearchBase) > 0
+}
+
+//ldapURLErrors accumulates one error per failed server URL so callers can see
+//why every host in a failover list was unreachable.
+type ldapURLErrors []error
+
+func (e ldapURLErrors) Error() string {
+	strs := make([]string, len(e))
+	for i, err := range e {
+		strs[i] = err.Error()
+	}
+	return strings.Join(strs, "; ")
 }
 
+//LDAPConnect dials each of the configured ServerURLs in order, returning the
+//first successful connection. Errors from failed hosts are accumulated and
+//returned together only if every host fails.
 func (m *LDAPIdentityProvider) LDAPConnect() (*ldap.Conn, error) {
-	var err error
-	var ldapConn *ldap.Conn
+	ldapConn, _, err := m.dialAny()
+	return ldapConn, err
+}
 
+//dialAny is LDAPConnect plus the server URL that was actually dialed, so the
+//connection pool can key idle connections by it.
+func (m *LDAPIdentityProvider) dialAny() (*ldap.Conn, string, error) {
 	log.Debugf("LDAPConnect()")
-	if m.useSSL {
-		ldapConn, err = ldap.DialTLS("tcp", fmt.Sprintf("%s:%d", m.serverHost, m.serverPort), m.tlsConfig)
+
+	var errs ldapURLErrors
+	for _, rawurl := range m.serverURLs {
+		ldapConn, err := m.dialServerURL(rawurl)
 		if err != nil {
-			return nil, err
+			errs = append(errs, fmt.Errorf("%s: %v", rawurl, err))
+			continue
 		}
-	} else {
-		ldapConn, err = ldap.Dial("tcp", fmt.Sprintf("%s:%d", m.serverHost, m.serverPort))
+		return ldapConn, rawurl, nil
+	}
+
+	return nil, "", errs
+}
+
+//parseLDAPURL resolves an LDAP server URL to a host and port to dial and
+//whether it requires an immediate TLS handshake (scheme "ldaps") as opposed
+//to a plain or StartTLS-upgraded connection (scheme "ldap" or no scheme).
+//The port defaults to 389/636 from the scheme when the URL does not specify
+//one.
+func parseLDAPURL(rawurl string) (host, port string, useSSL bool, err error) {
+	// A bare "host" or "host:port" has no "://" authority marker, so
+	// url.Parse would stuff it into u.Path and leave u.Host empty. Prepend
+	// "//" so it parses as an authority instead.
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "//" + rawurl
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ldaps":
+		useSSL = true
+	case "ldap", "":
+	default:
+		return "", "", false, fmt.Errorf("unsupported LDAP URL scheme %q", u.Scheme)
+	}
+
+	host = u.Hostname()
+	port = u.Port()
+	if port == "" {
+		if useSSL {
+			port = "636"
+		} else {
+			port = "389"
+		}
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", false, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+
+	return host, port, useSSL, nil
+}
+
+//dialServerURL dials a single LDAP server URL, picking LDAPS or plain per
+//parseLDAPURL and upgrading a plain connection with StartTLS when useTLS is
+//set. cfg.Timeout is applied as the net.Dialer connect timeout;
+//ldap.DefaultTimeout is not used here, since the connection is dialed
+//directly rather than through ldap.Dial or ldap.DialTLS.
+func (m *LDAPIdentityProvider) dialServerURL(rawurl string) (*ldap.Conn, error) {
+	host, port, useSSL, err := parseLDAPURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(host, port)
+
+	dialer := &net.Dialer{Timeout: m.cfg.Timeout * time.Millisecond}
+
+	tlsConfig := m.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+
+	if useSSL {
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
 		if err != nil {
 			return nil, err
 		}
-		if m.useTLS {
-			err = ldapConn.StartTLS(m.tlsConfig)
-			if err != nil {
-				return nil, err
+		ldapConn := ldap.NewConn(conn, true)
+		ldapConn.Start()
+		return ldapConn, nil
+	}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ldapConn := ldap.NewConn(conn, false)
+	ldapConn.Start()
+
+	if m.useTLS {
+		if err := ldapConn.StartTLS(tlsConfig); err != nil {
+			ldapConn.Close()
+			return nil, err
+		}
+	}
+
+	return ldapConn, nil
+}
+
+//pooledLDAPConn is a connection held by an ldapConnPool, already bound as the
+//configured search user. reused marks a connection handed out of the idle
+//list rather than freshly dialed by this get() call, so callers know whether
+//a failure on first use is worth retrying against a new connection.
+type pooledLDAPConn struct {
+	conn      *ldap.Conn
+	serverURL string
+	createdAt time.Time
+	idleSince time.Time
+	reused    bool
+}
+
+//ldapConnPool is a bounded pool of pre-bound search connections, keyed by
+//server URL, so repeated searches avoid paying the dial/TLS/bind cost that
+//otherwise dominates LDAP auth latency under load. PoolSize bounds the total
+//number of connections outstanding at once, whether idle or borrowed: a
+//semaphore is acquired before every dial and released only when a connection
+//is actually closed, so a burst of concurrent logins past PoolSize blocks on
+//dial rather than opening unbounded simultaneous connections to the
+//directory. Connections used for the user bind itself are never pooled; see
+//LDAPIdentityProvider.Identity.
+type ldapConnPool struct {
+	mu          sync.Mutex
+	idle        map[string][]*pooledLDAPConn
+	size        int
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+	sem         chan struct{}
+}
+
+func newLDAPConnPool(size int, idleTimeout, maxLifetime time.Duration) *ldapConnPool {
+	p := &ldapConnPool{
+		idle:        make(map[string][]*pooledLDAPConn),
+		size:        size,
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+	}
+	if size > 0 {
+		p.sem = make(chan struct{}, size)
+	}
+	return p
+}
+
+func (p *ldapConnPool) acquire() {
+	if p.sem != nil {
+		p.sem <- struct{}{}
+	}
+}
+
+func (p *ldapConnPool) release() {
+	if p.sem != nil {
+		<-p.sem
+	}
+}
+
+//get returns an idle pooled connection if one is available and not expired,
+//otherwise blocks until a slot under PoolSize is free and dials a fresh
+//connection bound as the search user.
+func (p *ldapConnPool) get(m *LDAPIdentityProvider) (*pooledLDAPConn, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	for serverURL, conns := range p.idle {
+		for len(conns) > 0 {
+			pc := conns[len(conns)-1]
+			conns = conns[:len(conns)-1]
+			p.idle[serverURL] = conns
+
+			if p.expired(pc, now) {
+				pc.conn.Close()
+				p.release()
+				continue
 			}
+
+			p.mu.Unlock()
+			pc.reused = true
+			return pc, nil
 		}
 	}
+	p.mu.Unlock()
 
-	return ldapConn, err
+	return p.dial(m)
+}
+
+//dial acquires a slot in the PoolSize semaphore, blocking if the pool is
+//already at capacity, then dials and binds a fresh search connection.
+func (p *ldapConnPool) dial(m *LDAPIdentityProvider) (*pooledLDAPConn, error) {
+	p.acquire()
+
+	ldapConn, serverURL, err := m.dialAny()
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+
+	if err := ldapConn.Bind(m.searchBindDN, m.searchBindPw); err != nil {
+		ldapConn.Close()
+		p.release()
+		return nil, err
+	}
+
+	return &pooledLDAPConn{conn: ldapConn, serverURL: serverURL, createdAt: time.Now()}, nil
+}
+
+func (p *ldapConnPool) expired(pc *pooledLDAPConn, now time.Time) bool {
+	if p.maxLifetime > 0 && now.Sub(pc.createdAt) > p.maxLifetime {
+		return true
+	}
+	if p.idleTimeout > 0 && now.Sub(pc.idleSince) > p.idleTimeout {
+		return true
+	}
+	return false
+}
+
+//put returns a connection to the pool for reuse, discarding it instead if
+//the pool for its server URL is already full or it has aged out.
+func (p *ldapConnPool) put(pc *pooledLDAPConn) {
+	pc.idleSince = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.expired(pc, pc.idleSince) || (p.size > 0 && len(p.idle[pc.serverURL]) >= p.size) {
+		pc.conn.Close()
+		p.release()
+		return
+	}
+	p.idle[pc.serverURL] = append(p.idle[pc.serverURL], pc)
+}
+
+//discard closes a borrowed connection instead of returning it to the pool,
+//used when the connection may be left in a bad state (e.g. a failed search).
+func (p *ldapConnPool) discard(pc *pooledLDAPConn) {
+	pc.conn.Close()
+	p.release()
+}
+
+//withPool borrows a pooled connection and calls fn with its underlying
+//*ldap.Conn, returning the connection to the pool on success or discarding
+//it on failure. Directories and the load balancers in front of them often
+//close idle connections server-side well before PoolIdleTimeout elapses on
+//the client, so a reused connection can pass expired() yet fail on first
+//use; when that happens, fn is retried once against a freshly dialed
+//connection before the error is returned to the caller.
+func (m *LDAPIdentityProvider) withPool(fn func(*ldap.Conn) error) error {
+	pc, err := m.pool.get(m)
+	if err != nil {
+		return err
+	}
+
+	err = fn(pc.conn)
+	if err != nil && pc.reused {
+		m.pool.discard(pc)
+		if pc, err = m.pool.dial(m); err != nil {
+			return err
+		}
+		err = fn(pc.conn)
+	}
+
+	if err != nil {
+		m.pool.discard(pc)
+		return err
+	}
+
+	m.pool.put(pc)
+	return nil
 }
 
 //ParseString parses config variables into the search string provided in the config.
@@ -283,23 +757,10 @@ func (m *LDAPIdentityProvider) ParseString(template, username string) string {
 }
 
 func (m *LDAPIdentityProvider) Identity(username, password string) (*oidc.Identity, error) {
-	var err error
 	var bindDN, ldapUid, ldapName, ldapEmail string
 	var claims jose.Claims
-	var ldapConn *ldap.Conn
-
-	ldapConn, err = m.LDAPConnect()
-	if err != nil {
-		return nil, err
-	}
-	defer ldapConn.Close()
 
 	if m.searchBeforeAuth {
-		err = ldapConn.Bind(m.searchBindDN, m.searchBindPw)
-		if err != nil {
-			return nil, err
-		}
-
 		filter := m.ParseString(m.searchFilter, username)
 
 		attributes := make([]string, 0, len(m.cfg.ldapAttrs)+2)
@@ -308,13 +769,17 @@ func (m *LDAPIdentityProvider) Identity(username, password string) (*oidc.Identi
 
 		s := ldap.NewSearchRequest(m.cfg.BaseDN, m.searchScope, ldap.NeverDerefAliases, 0, 0, false, filter, attributes, nil)
 
-		sr, err := ldapConn.Search(s)
+		var sr *ldap.SearchResult
+		err := m.withPool(func(conn *ldap.Conn) error {
+			var err error
+			sr, err = conn.SearchWithPaging(s, m.searchPageSize)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
 		if len(sr.Entries) == 0 {
-			err = fmt.Errorf("Search returned no match. filter='%v' base='%v'", filter, m.cfg.BaseDN)
-			return nil, err
+			return nil, fmt.Errorf("Search returned no match. filter='%v' base='%v'", filter, m.cfg.BaseDN)
 		}
 
 		bindDN = sr.Entries[0].DN
@@ -322,25 +787,36 @@ func (m *LDAPIdentityProvider) Identity(username, password string) (*oidc.Identi
 		ldapEmail = sr.Entries[0].GetAttributeValue(m.emailAttribute)
 
 		for _, attr := range sr.Entries[0].Attributes {
-			claims.Add(m.cfg.Attributes[attr.Name], attr.Values)
-		}
+			mapping, ok := m.cfg.Attributes[attr.Name]
+			if !ok {
+				continue
+			}
 
-		// drop to anonymous bind, prepare for bind as user
-		err = ldapConn.Bind("", "")
-		if err != nil {
-			// unsupported or disallowed, reconnect
-			log.Warningf("Re-connecting to LDAP Server after failure to bind anonymously: %v", err)
-			ldapConn.Close()
-			ldapConn, err = m.LDAPConnect()
+			values, err := mapping.transformValues(attr.Values)
 			if err != nil {
 				return nil, err
 			}
+
+			if mapping.Single {
+				if len(values) > 0 {
+					claims.Add(mapping.Claim, values[0])
+				}
+				continue
+			}
+			claims.Add(mapping.Claim, values)
 		}
 	} else {
 		bindDN = m.ParseString(m.bindTemplate, username)
 	}
 
-	// authenticate user
+	// authenticate user on a fresh, short-lived connection so a bad bind
+	// attempt never pollutes a pooled search connection
+	ldapConn, err := m.LDAPConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer ldapConn.Close()
+
 	err = ldapConn.Bind(bindDN, password)
 	if err != nil {
 		return nil, err
@@ -348,6 +824,22 @@ func (m *LDAPIdentityProvider) Identity(username, password string) (*oidc.Identi
 
 	ldapUid = bindDN
 
+	if m.groupsConfigured() {
+		var groups []string
+		err := m.withPool(func(conn *ldap.Conn) error {
+			var err error
+			groups, err = m.lookupGroups(conn, username, bindDN)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(groups) > 0 {
+			claims.Add(m.groupsClaim, groups)
+		}
+	}
+
 	return &oidc.Identity{
 		ID:     ldapUid,
 		Name:   ldapName,
@@ -355,3 +847,90 @@ func (m *LDAPIdentityProvider) Identity(username, password string) (*oidc.Identi
 		Claims: claims,
 	}, nil
 }
+
+//lookupGroups resolves the groups the authenticated user (username, bound as
+//userDN) belongs to, either via a group search or, when groupMemberOfAttribute
+//is configured, by reading group DNs directly off the user's own entry.
+func (m *LDAPIdentityProvider) lookupGroups(ldapConn *ldap.Conn, username, userDN string) ([]string, error) {
+	if len(m.groupMemberOfAttribute) > 0 {
+		return m.lookupGroupsFromMemberOf(ldapConn, userDN)
+	}
+	return m.lookupGroupsFromSearch(ldapConn, username, userDN)
+}
+
+//escapeLDAPFilterValue escapes the RFC 4515 special characters in a filter
+//value so it cannot be used to inject additional clauses into a search
+//filter when substituted from attacker-controlled input such as username.
+func escapeLDAPFilterValue(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\5c`)
+		case '*':
+			b.WriteString(`\2a`)
+		case '(':
+			b.WriteString(`\28`)
+		case ')':
+			b.WriteString(`\29`)
+		case 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (m *LDAPIdentityProvider) lookupGroupsFromSearch(ldapConn *ldap.Conn, username, userDN string) ([]string, error) {
+	filter := strings.NewReplacer(
+		"%u", escapeLDAPFilterValue(username),
+		"%d", escapeLDAPFilterValue(userDN),
+	).Replace(m.groupSearchFilter)
+
+	s := ldap.NewSearchRequest(m.groupSearchBase, m.groupSearchScope, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{m.groupNameAttribute}, nil)
+
+	sr, err := ldapConn.Search(s)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(sr.Entries))
+	for _, entry := range sr.Entries {
+		if name := entry.GetAttributeValue(m.groupNameAttribute); len(name) > 0 {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+func (m *LDAPIdentityProvider) lookupGroupsFromMemberOf(ldapConn *ldap.Conn, userDN string) ([]string, error) {
+	s := ldap.NewSearchRequest(userDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{m.groupMemberOfAttribute}, nil)
+
+	sr, err := ldapConn.Search(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) == 0 {
+		return nil, nil
+	}
+
+	groupDNs := sr.Entries[0].GetAttributeValues(m.groupMemberOfAttribute)
+	groups := make([]string, 0, len(groupDNs))
+	for _, dn := range groupDNs {
+		groups = append(groups, groupNameFromDN(dn))
+	}
+	return groups, nil
+}
+
+//groupNameFromDN returns the value of a group DN's leading RDN (typically
+//CN=<name>), falling back to the raw DN if it cannot be parsed.
+func groupNameFromDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
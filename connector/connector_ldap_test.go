@@ -0,0 +1,232 @@
+package connector
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLDAPURL(t *testing.T) {
+	tests := []struct {
+		rawurl     string
+		wantHost   string
+		wantPort   string
+		wantUseSSL bool
+		wantErr    bool
+	}{
+		{rawurl: "ldap://host1:389", wantHost: "host1", wantPort: "389", wantUseSSL: false},
+		{rawurl: "ldaps://host2:636", wantHost: "host2", wantPort: "636", wantUseSSL: true},
+		{rawurl: "ldap://host1", wantHost: "host1", wantPort: "389", wantUseSSL: false},
+		{rawurl: "ldaps://host2", wantHost: "host2", wantPort: "636", wantUseSSL: true},
+		{rawurl: "host1", wantHost: "host1", wantPort: "389", wantUseSSL: false},
+		{rawurl: "ldap://host1:notaport", wantErr: true},
+		{rawurl: "foo://host1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		host, port, useSSL, err := parseLDAPURL(tt.rawurl)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseLDAPURL(%q): expected error, got none", tt.rawurl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseLDAPURL(%q): unexpected error: %v", tt.rawurl, err)
+			continue
+		}
+		if host != tt.wantHost || port != tt.wantPort || useSSL != tt.wantUseSSL {
+			t.Errorf("parseLDAPURL(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tt.rawurl, host, port, useSSL, tt.wantHost, tt.wantPort, tt.wantUseSSL)
+		}
+	}
+}
+
+func TestLDAPConnPoolExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		createdAt   time.Time
+		idleSince   time.Time
+		want        bool
+	}{
+		{name: "fresh, no limits", createdAt: now, idleSince: now, want: false},
+		{
+			name:        "within idle timeout",
+			idleTimeout: time.Minute,
+			createdAt:   now,
+			idleSince:   now.Add(-30 * time.Second),
+			want:        false,
+		},
+		{
+			name:        "past idle timeout",
+			idleTimeout: time.Minute,
+			createdAt:   now,
+			idleSince:   now.Add(-2 * time.Minute),
+			want:        true,
+		},
+		{
+			name:        "within max lifetime",
+			maxLifetime: time.Hour,
+			createdAt:   now.Add(-30 * time.Minute),
+			idleSince:   now,
+			want:        false,
+		},
+		{
+			name:        "past max lifetime",
+			maxLifetime: time.Hour,
+			createdAt:   now.Add(-2 * time.Hour),
+			idleSince:   now,
+			want:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		p := &ldapConnPool{idleTimeout: tt.idleTimeout, maxLifetime: tt.maxLifetime}
+		pc := &pooledLDAPConn{createdAt: tt.createdAt, idleSince: tt.idleSince}
+
+		if got := p.expired(pc, now); got != tt.want {
+			t.Errorf("%s: expired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLDAPFilterValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "alice", want: "alice"},
+		{in: "*)(|(uid=*", want: `\2a\29\28|\28uid=\2a`},
+		{in: `back\slash`, want: `back\5cslash`},
+		{in: "CN=alice,OU=people,DC=example,DC=com", want: "CN=alice,OU=people,DC=example,DC=com"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeLDAPFilterValue(tt.in); got != tt.want {
+			t.Errorf("escapeLDAPFilterValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGroupNameFromDN(t *testing.T) {
+	tests := []struct {
+		dn   string
+		want string
+	}{
+		{dn: "CN=admins,OU=groups,DC=example,DC=com", want: "admins"},
+		{dn: "cn=Engineers,dc=example,dc=com", want: "Engineers"},
+		{dn: "not a dn", want: "not a dn"},
+	}
+
+	for _, tt := range tests {
+		if got := groupNameFromDN(tt.dn); got != tt.want {
+			t.Errorf("groupNameFromDN(%q) = %q, want %q", tt.dn, got, tt.want)
+		}
+	}
+}
+
+func TestAttributeMappingUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want AttributeMapping
+	}{
+		{
+			name: "bare string is treated as claim name",
+			json: `"email"`,
+			want: AttributeMapping{Claim: "email"},
+		},
+		{
+			name: "full object form",
+			json: `{"claim": "groups", "transform": "dn_cn", "single": true}`,
+			want: AttributeMapping{Claim: "groups", Transform: "dn_cn", Single: true},
+		},
+	}
+
+	for _, tt := range tests {
+		var got AttributeMapping
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Errorf("%s: Unmarshal(%s): unexpected error: %v", tt.name, tt.json, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Unmarshal(%s) = %+v, want %+v", tt.name, tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestAttributeMappingTransformValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping AttributeMapping
+		in      []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "raw is the default and passes values through unchanged",
+			mapping: AttributeMapping{},
+			in:      []string{"a", "b"},
+			want:    []string{"a", "b"},
+		},
+		{
+			name:    "explicit raw",
+			mapping: AttributeMapping{Transform: AttributeTransformRaw},
+			in:      []string{"a", "b"},
+			want:    []string{"a", "b"},
+		},
+		{
+			name:    "first keeps only the first value",
+			mapping: AttributeMapping{Transform: AttributeTransformFirst},
+			in:      []string{"a", "b"},
+			want:    []string{"a"},
+		},
+		{
+			name:    "first on no values",
+			mapping: AttributeMapping{Transform: AttributeTransformFirst},
+			in:      nil,
+			want:    nil,
+		},
+		{
+			name:    "dn_cn extracts the leading RDN",
+			mapping: AttributeMapping{Transform: AttributeTransformDNCN},
+			in:      []string{"CN=admins,DC=example,DC=com"},
+			want:    []string{"admins"},
+		},
+		{
+			name:    "base64 encodes each value",
+			mapping: AttributeMapping{Transform: AttributeTransformBase64},
+			in:      []string{"hello"},
+			want:    []string{base64.StdEncoding.EncodeToString([]byte("hello"))},
+		},
+		{
+			name:    "unknown transform errors",
+			mapping: AttributeMapping{Transform: "nonsense"},
+			in:      []string{"a"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.mapping.transformValues(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got none", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: transformValues(%v) = %v, want %v", tt.name, tt.in, got, tt.want)
+		}
+	}
+}